@@ -0,0 +1,187 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// grant is a single, structured database/privilege pair, letting a role
+// request fine-grained InfluxDB privileges instead of hardcoding
+// GRANT ALL ON "vault" into a Commands template.
+type grant struct {
+	Database  string `json:"database"`
+	Privilege string `json:"privilege"`
+}
+
+// grantsStatement is the JSON shape a role embeds in one of the
+// Statements.Commands entries to request grants instead of (or alongside)
+// a literal InfluxQL creation statement.
+type grantsStatement struct {
+	Grants []grant `json:"grants"`
+	Admin  bool    `json:"admin"`
+}
+
+// parseGrantsStatement attempts to interpret a Commands entry as a
+// grantsStatement. Plain InfluxQL commands aren't valid JSON objects, so a
+// parse failure just means "this is a regular statement" rather than an
+// error.
+func parseGrantsStatement(command string) (grantsStatement, bool) {
+	var stmt grantsStatement
+	if err := json.Unmarshal([]byte(strings.TrimSpace(command)), &stmt); err != nil {
+		return grantsStatement{}, false
+	}
+	if len(stmt.Grants) == 0 && !stmt.Admin {
+		return grantsStatement{}, false
+	}
+	return stmt, true
+}
+
+// statement renders the InfluxQL GRANT statement for this privilege.
+func (g grant) statement(username string) (string, error) {
+	if g.Database == "" {
+		return "", fmt.Errorf("grant is missing a database")
+	}
+	if !validIdentifier.MatchString(g.Database) {
+		return "", fmt.Errorf("invalid database name %q", g.Database)
+	}
+
+	switch strings.ToUpper(g.Privilege) {
+	case "READ":
+		return fmt.Sprintf(`GRANT READ ON "%s" TO "%s"`, g.Database, username), nil
+	case "WRITE":
+		return fmt.Sprintf(`GRANT WRITE ON "%s" TO "%s"`, g.Database, username), nil
+	case "ALL":
+		return fmt.Sprintf(`GRANT ALL ON "%s" TO "%s"`, g.Database, username), nil
+	default:
+		return "", fmt.Errorf("unsupported privilege %q, must be one of READ, WRITE, ALL", g.Privilege)
+	}
+}
+
+// applyGrants issues the GRANT statements described by stmt for username,
+// returning the first error encountered.
+func (i *Influxdb) applyGrants(username string, stmt grantsStatement) error {
+	if stmt.Admin {
+		if err := i.runQuery(fmt.Sprintf(`GRANT ALL PRIVILEGES TO "%s"`, username)); err != nil {
+			return errwrap.Wrapf("error granting cluster-wide admin privileges: {{err}}", err)
+		}
+	}
+
+	for _, g := range stmt.Grants {
+		q, err := g.statement(username)
+		if err != nil {
+			return err
+		}
+		if err := i.runQuery(q); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("error applying grant on %q: {{err}}", g.Database), err)
+		}
+	}
+
+	return nil
+}
+
+// activeGrant is a privilege currently held by a user, as reported by
+// SHOW GRANTS FOR.
+type activeGrant struct {
+	Database  string
+	Privilege string
+}
+
+// currentGrants enumerates the privileges InfluxDB currently reports for
+// username via SHOW GRANTS FOR, so RevokeUser can explicitly revoke each
+// one before dropping the user rather than relying solely on DROP USER to
+// clean up, which leaves orphaned privileges behind if it's interrupted
+// partway through on a clustered server.
+func (i *Influxdb) currentGrants(username string) ([]activeGrant, error) {
+	response, err := i.client.Query(influx.NewQuery(fmt.Sprintf(`SHOW GRANTS FOR "%s"`, username), "", ""))
+	if err != nil {
+		return nil, err
+	}
+	if response != nil && response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	var grants []activeGrant
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			dbIdx, privIdx := -1, -1
+			for idx, col := range series.Columns {
+				switch col {
+				case "database":
+					dbIdx = idx
+				case "privilege":
+					privIdx = idx
+				}
+			}
+			if dbIdx == -1 || privIdx == -1 {
+				continue
+			}
+			for _, row := range series.Values {
+				grants = append(grants, activeGrant{
+					Database:  fmt.Sprintf("%v", row[dbIdx]),
+					Privilege: fmt.Sprintf("%v", row[privIdx]),
+				})
+			}
+		}
+	}
+	return grants, nil
+}
+
+// isAdmin reports whether username currently holds InfluxDB's cluster-wide
+// admin privilege. SHOW GRANTS FOR never reports admin status, so
+// ReconcileRoleGrants needs this separately from currentGrants to detect
+// drift on a role that declares Privilege{Admin: true}.
+func (i *Influxdb) isAdmin(username string) (bool, error) {
+	response, err := i.client.Query(influx.NewQuery("SHOW USERS", "", ""))
+	if err != nil {
+		return false, err
+	}
+	if response != nil && response.Error() != nil {
+		return false, response.Error()
+	}
+
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			userIdx, adminIdx := -1, -1
+			for idx, col := range series.Columns {
+				switch col {
+				case "user":
+					userIdx = idx
+				case "admin":
+					adminIdx = idx
+				}
+			}
+			if userIdx == -1 || adminIdx == -1 {
+				continue
+			}
+			for _, row := range series.Values {
+				if fmt.Sprintf("%v", row[userIdx]) != username {
+					continue
+				}
+				if admin, ok := row[adminIdx].(bool); ok {
+					return admin, nil
+				}
+				return fmt.Sprintf("%v", row[adminIdx]) == "true", nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// revokeAll revokes every grant currently held by username.
+func (i *Influxdb) revokeAll(username string, grants []activeGrant) error {
+	for _, g := range grants {
+		priv := strings.ToUpper(g.Privilege)
+		if priv == "NO PRIVILEGES" || priv == "" {
+			continue
+		}
+		q := fmt.Sprintf(`REVOKE %s ON "%s" FROM "%s"`, priv, g.Database, username)
+		if err := i.runQuery(q); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("error revoking %s on %q: {{err}}", priv, g.Database), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package influxdb
+
+import "testing"
+
+func TestGrant_statement(t *testing.T) {
+	tests := map[string]struct {
+		grant   grant
+		want    string
+		wantErr bool
+	}{
+		"read": {
+			grant: grant{Database: "metrics", Privilege: "read"},
+			want:  `GRANT READ ON "metrics" TO "test"`,
+		},
+		"write": {
+			grant: grant{Database: "metrics", Privilege: "WRITE"},
+			want:  `GRANT WRITE ON "metrics" TO "test"`,
+		},
+		"all": {
+			grant: grant{Database: "metrics", Privilege: "all"},
+			want:  `GRANT ALL ON "metrics" TO "test"`,
+		},
+		"missing database": {
+			grant:   grant{Privilege: "READ"},
+			wantErr: true,
+		},
+		"database with a quote is rejected": {
+			grant:   grant{Database: `metrics" TO "attacker`, Privilege: "READ"},
+			wantErr: true,
+		},
+		"unsupported privilege": {
+			grant:   grant{Database: "metrics", Privilege: "DELETE"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := test.grant.statement("test")
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGrantsStatement(t *testing.T) {
+	tests := map[string]struct {
+		command string
+		wantOK  bool
+	}{
+		"plain influxql is not a grants statement": {
+			command: createUserStatements,
+			wantOK:  false,
+		},
+		"grants list": {
+			command: `{"grants":[{"database":"metrics","privilege":"read"}]}`,
+			wantOK:  true,
+		},
+		"admin only": {
+			command: `{"admin":true}`,
+			wantOK:  true,
+		},
+		"empty json object": {
+			command: `{}`,
+			wantOK:  false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, ok := parseGrantsStatement(test.command)
+			if ok != test.wantOK {
+				t.Fatalf("got ok=%v, want %v", ok, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+// Package influxdb provides a Vault database secrets engine plugin that
+// dynamically creates and revokes InfluxDB users.
+package influxdb
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
+	"github.com/hashicorp/vault/sdk/database/newdbplugin"
+	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	influxdbTypeName = "influxdb"
+
+	// pingTimeout bounds how long Initialize waits when VerifyConnection is
+	// requested.
+	pingTimeout = 5 * time.Second
+
+	// defaultPort is InfluxDB's standard HTTP API port, used when a
+	// connection URL omits one.
+	defaultPort = 8086
+
+	// generatedUsernameMaxLength bounds the username credsutil.GenerateUsername
+	// produces for NewUser, well under InfluxDB's identifier limits.
+	generatedUsernameMaxLength = 100
+)
+
+// Influxdb implements newdbplugin.Database, managing the lifecycle of
+// dynamic InfluxDB users on behalf of Vault's database secrets engine.
+type Influxdb struct {
+	sync.Mutex
+
+	Initialized bool
+
+	config         connectionConfig
+	passwordPolicy passwordPolicy
+	client         influx.Client
+
+	// manageRetentionPolicies controls whether DeleteUser drops the
+	// retention policies NewUser provisioned alongside a user; NewUser
+	// creates requested policies whether or not it is set. InfluxDB has no
+	// notion of policy ownership, so NewUser encodes
+	// it into each policy's name (see policyNamePrefix); DeleteUser
+	// rediscovers a user's policies via findOwnedRetentionPolicies instead
+	// of tracking them in process memory, so cleanup still works even if
+	// the plugin instance that created them has since restarted or been
+	// reloaded.
+	manageRetentionPolicies bool
+
+	// roles holds the optional, validated Role bundles configured under
+	// the `roles` key. When NewUserRequest.UsernameConfig.RoleName names
+	// a configured role, its privileges are granted directly instead of
+	// going through the Commands template.
+	roles map[string]Role
+}
+
+// New returns a new, uninitialized instance of the plugin for use by the
+// plugin's main package.
+func New() (interface{}, error) {
+	return new(), nil
+}
+
+func new() *Influxdb {
+	return &Influxdb{}
+}
+
+// Type returns the TypeName for this backend.
+func (i *Influxdb) Type() (string, error) {
+	return influxdbTypeName, nil
+}
+
+type connectionConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	URL      string `mapstructure:"url"`
+
+	TLS           bool   `mapstructure:"tls"`
+	InsecureTLS   bool   `mapstructure:"insecure_tls"`
+	TLSCA         string `mapstructure:"tls_ca"`
+	TLSCert       string `mapstructure:"tls_cert"`
+	TLSKey        string `mapstructure:"tls_key"`
+	TLSServerName string `mapstructure:"tls_server_name"`
+
+	ManageRetentionPolicies bool `mapstructure:"manage_retention_policies"`
+}
+
+func parseConnectionConfig(raw map[string]interface{}) (connectionConfig, error) {
+	var config connectionConfig
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &config,
+	})
+	if err != nil {
+		return connectionConfig{}, errwrap.Wrapf("error building config decoder: {{err}}", err)
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return connectionConfig{}, errwrap.Wrapf("error decoding connection configuration: {{err}}", err)
+	}
+
+	if config.URL != "" {
+		if err := config.applyURL(); err != nil {
+			return connectionConfig{}, err
+		}
+	}
+
+	if config.Host == "" {
+		return connectionConfig{}, fmt.Errorf("host is required")
+	}
+	if config.Username == "" {
+		return connectionConfig{}, fmt.Errorf("username is required")
+	}
+	return config, nil
+}
+
+func (c connectionConfig) httpConfig() (influx.HTTPConfig, error) {
+	scheme := "http"
+	var tlsConfig *tls.Config
+	if c.TLS || c.InsecureTLS {
+		scheme = "https"
+		cfg, err := c.buildTLSConfig()
+		if err != nil {
+			return influx.HTTPConfig{}, err
+		}
+		tlsConfig = cfg
+	}
+
+	return influx.HTTPConfig{
+		Addr:      fmt.Sprintf("%s://%s:%d", scheme, c.Host, c.Port),
+		Username:  c.Username,
+		Password:  c.Password,
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// Initialize parses and validates the connection configuration, optionally
+// verifies connectivity to the InfluxDB server, and caches the derived
+// client for later use by NewUser, UpdateUser and DeleteUser.
+func (i *Influxdb) Initialize(ctx context.Context, req newdbplugin.InitializeRequest) (newdbplugin.InitializeResponse, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	config, err := parseConnectionConfig(req.Config)
+	if err != nil {
+		return newdbplugin.InitializeResponse{}, err
+	}
+
+	policy, err := parsePasswordPolicy(req.Config)
+	if err != nil {
+		return newdbplugin.InitializeResponse{}, err
+	}
+
+	roles, err := parseRoles(req.Config)
+	if err != nil {
+		return newdbplugin.InitializeResponse{}, err
+	}
+
+	httpConfig, err := config.httpConfig()
+	if err != nil {
+		return newdbplugin.InitializeResponse{}, err
+	}
+
+	client, err := influx.NewHTTPClient(httpConfig)
+	if err != nil {
+		return newdbplugin.InitializeResponse{}, errwrap.Wrapf("error creating InfluxDB client: {{err}}", err)
+	}
+
+	if req.VerifyConnection {
+		if _, _, err := client.Ping(pingTimeout); err != nil {
+			client.Close()
+			return newdbplugin.InitializeResponse{}, errwrap.Wrapf("error verifying connection to InfluxDB: {{err}}", err)
+		}
+	}
+
+	if i.client != nil {
+		i.client.Close()
+	}
+
+	i.config = config
+	i.passwordPolicy = policy
+	i.roles = roles
+	i.client = client
+	i.manageRetentionPolicies = config.ManageRetentionPolicies
+	i.Initialized = true
+
+	return newdbplugin.InitializeResponse{
+		Config: req.Config,
+	}, nil
+}
+
+// NewUser creates a new InfluxDB user under a generated, unique username
+// (so concurrent leases for the same role never collide on CREATE USER). If
+// UsernameConfig.RoleName names a configured Role, the role's privileges
+// are granted directly and the Commands template is bypassed entirely;
+// otherwise the user and its grants are driven by the role's Commands as
+// before.
+func (i *Influxdb) NewUser(ctx context.Context, req newdbplugin.NewUserRequest) (newdbplugin.NewUserResponse, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.Initialized {
+		return newdbplugin.NewUserResponse{}, fmt.Errorf("database connection not initialized")
+	}
+
+	if err := i.passwordPolicy.validate(req.Password); err != nil {
+		return newdbplugin.NewUserResponse{}, err
+	}
+
+	username, err := credsutil.GenerateUsername(
+		credsutil.DisplayName(req.UsernameConfig.DisplayName, generatedUsernameMaxLength),
+		credsutil.RoleName(req.UsernameConfig.RoleName, generatedUsernameMaxLength),
+		credsutil.MaxLength(generatedUsernameMaxLength),
+		credsutil.Separator("_"),
+	)
+	if err != nil {
+		return newdbplugin.NewUserResponse{}, errwrap.Wrapf("error generating username: {{err}}", err)
+	}
+
+	if role, ok := i.lookupRole(req.UsernameConfig.RoleName); ok {
+		return i.newUserFromRole(username, req.Password, role)
+	}
+
+	if len(req.Statements.Commands) == 0 {
+		return newdbplugin.NewUserResponse{}, fmt.Errorf("creation statements are required")
+	}
+
+	var grantStatements []grantsStatement
+	var retentionStatements []retentionPoliciesStatement
+	var creationStatements []string
+
+	for _, stmt := range req.Statements.Commands {
+		if grantsStmt, ok := parseGrantsStatement(stmt); ok {
+			grantStatements = append(grantStatements, grantsStmt)
+			continue
+		}
+		if retentionStmt, ok := parseRetentionPoliciesStatement(stmt); ok {
+			retentionStatements = append(retentionStatements, retentionStmt)
+			continue
+		}
+		creationStatements = append(creationStatements, stmt)
+	}
+
+	// Retention policies are provisioned before the user itself, so that a
+	// role's GRANT statements can reference a database's policy immediately
+	// on creation.
+	for _, retentionStmt := range retentionStatements {
+		if _, err := i.applyRetentionPolicies(username, retentionStmt); err != nil {
+			return newdbplugin.NewUserResponse{}, err
+		}
+	}
+
+	for _, stmt := range creationStatements {
+		for _, query := range splitStatements(stmt) {
+			q := strings.ReplaceAll(query, "{{username}}", username)
+			q = strings.ReplaceAll(q, "{{password}}", req.Password)
+
+			if err := i.runQuery(q); err != nil {
+				return newdbplugin.NewUserResponse{}, errwrap.Wrapf("error creating user: {{err}}", err)
+			}
+		}
+	}
+
+	for _, grantsStmt := range grantStatements {
+		if err := i.applyGrants(username, grantsStmt); err != nil {
+			if dropErr := i.runQuery(fmt.Sprintf(`DROP USER "%s"`, username)); dropErr != nil {
+				return newdbplugin.NewUserResponse{}, errwrap.Wrapf(
+					fmt.Sprintf("error applying grants: {{err}}; additionally failed to roll back created user %q: %s", username, dropErr), err)
+			}
+			return newdbplugin.NewUserResponse{}, errwrap.Wrapf("error applying grants, user rolled back: {{err}}", err)
+		}
+	}
+
+	return newdbplugin.NewUserResponse{
+		Username: username,
+	}, nil
+}
+
+// newUserFromRole creates username with password and grants it role's
+// privileges directly, rolling the user back if any grant fails.
+func (i *Influxdb) newUserFromRole(username, password string, role Role) (newdbplugin.NewUserResponse, error) {
+	createStmt := fmt.Sprintf(`CREATE USER "%s" WITH PASSWORD '%s'`, username, password)
+	if err := i.runQuery(createStmt); err != nil {
+		return newdbplugin.NewUserResponse{}, errwrap.Wrapf("error creating user: {{err}}", err)
+	}
+
+	if err := i.applyGrants(username, role.grantsStatement()); err != nil {
+		if dropErr := i.runQuery(fmt.Sprintf(`DROP USER "%s"`, username)); dropErr != nil {
+			return newdbplugin.NewUserResponse{}, errwrap.Wrapf(
+				fmt.Sprintf("error applying role grants: {{err}}; additionally failed to roll back created user %q: %s", username, dropErr), err)
+		}
+		return newdbplugin.NewUserResponse{}, errwrap.Wrapf("error applying role grants, user rolled back: {{err}}", err)
+	}
+
+	return newdbplugin.NewUserResponse{
+		Username: username,
+	}, nil
+}
+
+// UpdateUser applies a password rotation to an existing InfluxDB user.
+// Expiration changes are a no-op since InfluxDB has no concept of
+// credential expiration.
+func (i *Influxdb) UpdateUser(ctx context.Context, req newdbplugin.UpdateUserRequest) (newdbplugin.UpdateUserResponse, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.Initialized {
+		return newdbplugin.UpdateUserResponse{}, fmt.Errorf("database connection not initialized")
+	}
+
+	if req.Password == nil {
+		return newdbplugin.UpdateUserResponse{}, nil
+	}
+
+	if err := i.passwordPolicy.validate(req.Password.NewPassword); err != nil {
+		return newdbplugin.UpdateUserResponse{}, err
+	}
+
+	q := fmt.Sprintf(`SET PASSWORD FOR "%s" = '%s'`, req.Username, req.Password.NewPassword)
+	if err := i.runQuery(q); err != nil {
+		return newdbplugin.UpdateUserResponse{}, errwrap.Wrapf("error updating user password: {{err}}", err)
+	}
+
+	return newdbplugin.UpdateUserResponse{}, nil
+}
+
+// DeleteUser revokes every privilege held by the named user before
+// dropping it, so a failure partway through doesn't leave orphaned
+// privileges attributed to a user that no longer exists from Vault's
+// perspective.
+func (i *Influxdb) DeleteUser(ctx context.Context, req newdbplugin.DeleteUserRequest) (newdbplugin.DeleteUserResponse, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.Initialized {
+		return newdbplugin.DeleteUserResponse{}, fmt.Errorf("database connection not initialized")
+	}
+
+	grants, err := i.currentGrants(req.Username)
+	if err != nil {
+		return newdbplugin.DeleteUserResponse{}, errwrap.Wrapf("error enumerating grants: {{err}}", err)
+	}
+
+	if err := i.revokeAll(req.Username, grants); err != nil {
+		return newdbplugin.DeleteUserResponse{}, err
+	}
+
+	q := fmt.Sprintf(`DROP USER "%s"`, req.Username)
+	if err := i.runQuery(q); err != nil {
+		return newdbplugin.DeleteUserResponse{}, errwrap.Wrapf("error dropping user: {{err}}", err)
+	}
+
+	if i.manageRetentionPolicies {
+		databases, err := i.databases()
+		if err != nil {
+			return newdbplugin.DeleteUserResponse{}, errwrap.Wrapf("error listing databases: {{err}}", err)
+		}
+		policies, err := i.findOwnedRetentionPolicies(req.Username, databases)
+		if err != nil {
+			return newdbplugin.DeleteUserResponse{}, errwrap.Wrapf("error finding retention policies: {{err}}", err)
+		}
+		if err := i.dropRetentionPolicies(policies); err != nil {
+			return newdbplugin.DeleteUserResponse{}, err
+		}
+	}
+
+	return newdbplugin.DeleteUserResponse{}, nil
+}
+
+// Close releases the underlying InfluxDB client.
+func (i *Influxdb) Close() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.client != nil {
+		i.client.Close()
+	}
+	i.Initialized = false
+	return nil
+}
+
+func (i *Influxdb) runQuery(q string) error {
+	response, err := i.client.Query(influx.NewQuery(q, "", ""))
+	if err != nil {
+		return err
+	}
+	if response != nil && response.Error() != nil {
+		return response.Error()
+	}
+	return nil
+}
+
+// splitStatements splits a semicolon-delimited statement block into its
+// individual, non-empty queries.
+func splitStatements(block string) []string {
+	var out []string
+	for _, stmt := range strings.Split(block, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
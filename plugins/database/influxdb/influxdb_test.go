@@ -2,9 +2,16 @@ package influxdb
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -96,6 +103,76 @@ func prepareInfluxdbTestContainer(t *testing.T) (func(), *Config) {
 	return svc.Cleanup, svc.Config.(*Config)
 }
 
+// TLSConfig bundles a running, TLS-enabled InfluxDB container with the
+// self-signed certificate material it was started with, so tests can
+// exercise both the trusting (tls_ca set) and untrusting (tls_ca unset)
+// paths against the same server.
+type TLSConfig struct {
+	Config
+	CACertPEM string
+}
+
+func (c *TLSConfig) connectionParams() map[string]interface{} {
+	params := c.Config.connectionParams()
+	params["tls"] = true
+	params["tls_ca"] = c.CACertPEM
+	params["tls_server_name"] = "localhost"
+	return params
+}
+
+func (c *TLSConfig) url() string {
+	return fmt.Sprintf("https://%s:%s@%s", c.Username, c.Password, c.Address())
+}
+
+func prepareInfluxdbTLSTestContainer(t *testing.T) (func(), *TLSConfig) {
+	certPEM, keyPEM, err := generateSelfSignedCert("localhost")
+	if err != nil {
+		t.Fatalf("could not generate self-signed certificate: %s", err)
+	}
+
+	c := &TLSConfig{
+		Config: Config{
+			Username: "influx-root",
+			Password: "influx-root",
+		},
+		CACertPEM: certPEM,
+	}
+
+	runner, err := docker.NewServiceRunner(docker.RunOptions{
+		ImageRepo: "influxdb",
+		ImageTag:  "alpine",
+		Env: []string{
+			"INFLUXDB_DB=vault",
+			"INFLUXDB_ADMIN_USER=" + c.Username,
+			"INFLUXDB_ADMIN_PASSWORD=" + c.Password,
+			"INFLUXDB_HTTP_AUTH_ENABLED=true",
+			"INFLUXDB_HTTP_HTTPS_ENABLED=true",
+			"INFLUXDB_HTTP_HTTPS_CERTIFICATE=/etc/ssl/influxdb.pem",
+			"INFLUXDB_HTTP_HTTPS_PRIVATE_KEY=/etc/ssl/influxdb-key.pem",
+		},
+		Ports: []string{"8086/tcp"},
+		CopyFromTo: map[string]string{
+			writeTempFile(t, "influxdb.pem", certPEM):    "/etc/ssl/influxdb.pem",
+			writeTempFile(t, "influxdb-key.pem", keyPEM): "/etc/ssl/influxdb-key.pem",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not start docker InfluxDB: %s", err)
+	}
+	svc, err := runner.StartService(context.Background(), func(ctx context.Context, host string, port int) (docker.ServiceConfig, error) {
+		c.ServiceURL = *docker.NewServiceURL(url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s:%d", host, port),
+		})
+		return c, nil
+	})
+	if err != nil {
+		t.Fatalf("Could not start docker InfluxDB: %s", err)
+	}
+
+	return svc.Cleanup, svc.Config.(*TLSConfig)
+}
+
 func TestInfluxdb_Initialize(t *testing.T) {
 	cleanup, config := prepareInfluxdbTestContainer(t)
 	defer cleanup()
@@ -242,6 +319,339 @@ func TestInfluxdb_CreateUser(t *testing.T) {
 	assertCredsExist(t, config.URL().String(), resp.Username, password)
 }
 
+// TestInfluxdb_CreateUser_grants verifies that a structured grants
+// statement is translated into per-database GRANT statements. Rollback of
+// the created user on a failing grant is covered by
+// TestInfluxdb_CreateUser_badGrant.
+func TestInfluxdb_CreateUser_grants(t *testing.T) {
+	cleanup, config := prepareInfluxdbTestContainer(t)
+	defer cleanup()
+
+	db := new()
+	req := newdbplugin.InitializeRequest{
+		Config:           config.connectionParams(),
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, req)
+
+	password := "nuozxby98523u89bdfnkjl"
+	grantsStatement := `{"grants":[{"database":"vault","privilege":"read"}]}`
+	newUserReq := newdbplugin.NewUserRequest{
+		UsernameConfig: newdbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: newdbplugin.Statements{
+			Commands: []string{createUserStatements, grantsStatement},
+		},
+		Password:   password,
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+	resp := dbtesting.AssertNewUser(t, db, newUserReq)
+
+	assertCredsExist(t, config.URL().String(), resp.Username, password)
+
+	grants, err := db.currentGrants(resp.Username)
+	if err != nil {
+		t.Fatalf("error fetching grants: %s", err)
+	}
+
+	var found bool
+	for _, g := range grants {
+		if g.Database == "vault" && strings.EqualFold(g.Privilege, "READ") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected a READ grant on vault, got %#v", grants)
+	}
+}
+
+// TestInfluxdb_CreateUser_badGrant verifies that an invalid grant rolls
+// back the user created for it.
+func TestInfluxdb_CreateUser_badGrant(t *testing.T) {
+	cleanup, config := prepareInfluxdbTestContainer(t)
+	defer cleanup()
+
+	db := new()
+	req := newdbplugin.InitializeRequest{
+		Config:           config.connectionParams(),
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, req)
+
+	password := "nuozxby98523u89bdfnkjl"
+	grantsStatement := `{"grants":[{"database":"vault","privilege":"delete"}]}`
+	newUserReq := newdbplugin.NewUserRequest{
+		UsernameConfig: newdbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: newdbplugin.Statements{
+			Commands: []string{createUserStatements, grantsStatement},
+		},
+		Password:   password,
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+
+	usersBefore := listUsers(t, config.apiConfig())
+
+	_, err := db.NewUser(context.Background(), newUserReq)
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+
+	assertCredsDoNotExist(t, config.URL().String(), "test", password)
+	if usersAfter := listUsers(t, config.apiConfig()); !reflect.DeepEqual(usersBefore, usersAfter) {
+		t.Fatalf("expected the created user to be rolled back, users before: %v, after: %v", usersBefore, usersAfter)
+	}
+}
+
+// TestInfluxdb_CreateUser_retentionPolicies verifies that retention
+// policies requested alongside a user are visible via SHOW RETENTION
+// POLICIES after creation, and are removed once the user is revoked when
+// manage_retention_policies is enabled.
+func TestInfluxdb_CreateUser_retentionPolicies(t *testing.T) {
+	cleanup, config := prepareInfluxdbTestContainer(t)
+	defer cleanup()
+
+	db := new()
+	req := newdbplugin.InitializeRequest{
+		Config:           makeConfig(config.connectionParams(), "manage_retention_policies", true),
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, req)
+
+	password := "nuozxby98523u89bdfnkjl"
+	retentionStatement := `{"retention_policies":[{"name":"test_rp","database":"vault","duration":"24h","replication":1}]}`
+	newUserReq := newdbplugin.NewUserRequest{
+		UsernameConfig: newdbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: newdbplugin.Statements{
+			Commands: []string{createUserStatements, retentionStatement},
+		},
+		Password:   password,
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+	resp := dbtesting.AssertNewUser(t, db, newUserReq)
+
+	ownedName := policyNamePrefix(resp.Username) + "test_rp"
+	if !retentionPolicyExists(t, config.apiConfig(), "vault", ownedName) {
+		t.Fatalf("expected retention policy %q to exist after creation", ownedName)
+	}
+
+	delReq := newdbplugin.DeleteUserRequest{Username: resp.Username}
+	if _, err := db.DeleteUser(context.Background(), delReq); err != nil {
+		t.Fatalf("error deleting user: %s", err)
+	}
+
+	if retentionPolicyExists(t, config.apiConfig(), "vault", ownedName) {
+		t.Fatalf("expected retention policy %q to be removed after revoke", ownedName)
+	}
+}
+
+// TestInfluxdb_CreateUser_retentionPolicies_survivesReload verifies that a
+// second, independently-initialized plugin instance — standing in for a
+// Vault restart or plugin reload between NewUser and DeleteUser — can still
+// discover and remove the policies the first instance created, since
+// ownership is encoded in InfluxDB's own state rather than in-process
+// bookkeeping.
+func TestInfluxdb_CreateUser_retentionPolicies_survivesReload(t *testing.T) {
+	cleanup, config := prepareInfluxdbTestContainer(t)
+	defer cleanup()
+
+	creator := new()
+	req := newdbplugin.InitializeRequest{
+		Config:           makeConfig(config.connectionParams(), "manage_retention_policies", true),
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, creator, req)
+
+	password := "nuozxby98523u89bdfnkjl"
+	retentionStatement := `{"retention_policies":[{"name":"reload_rp","database":"vault","duration":"24h","replication":1}]}`
+	newUserReq := newdbplugin.NewUserRequest{
+		UsernameConfig: newdbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: newdbplugin.Statements{
+			Commands: []string{createUserStatements, retentionStatement},
+		},
+		Password:   password,
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+	resp := dbtesting.AssertNewUser(t, creator, newUserReq)
+
+	ownedName := policyNamePrefix(resp.Username) + "reload_rp"
+	if !retentionPolicyExists(t, config.apiConfig(), "vault", ownedName) {
+		t.Fatalf("expected retention policy %q to exist after creation", ownedName)
+	}
+
+	reloaded := new()
+	dbtesting.AssertInitialize(t, reloaded, req)
+
+	delReq := newdbplugin.DeleteUserRequest{Username: resp.Username}
+	if _, err := reloaded.DeleteUser(context.Background(), delReq); err != nil {
+		t.Fatalf("error deleting user from a freshly initialized instance: %s", err)
+	}
+
+	if retentionPolicyExists(t, config.apiConfig(), "vault", ownedName) {
+		t.Fatalf("expected retention policy %q to be removed after revoke from a different plugin instance", ownedName)
+	}
+}
+
+func retentionPolicyExists(t *testing.T, apiConfig influx.HTTPConfig, database, name string) bool {
+	t.Helper()
+
+	cli, err := influx.NewHTTPClient(apiConfig)
+	if err != nil {
+		t.Fatalf("error creating InfluxDB client: %s", err)
+	}
+	defer cli.Close()
+
+	response, err := cli.Query(influx.NewQuery(fmt.Sprintf(`SHOW RETENTION POLICIES ON "%s"`, database), "", ""))
+	if err != nil {
+		t.Fatalf("error querying retention policies: %s", err)
+	}
+	if response.Error() != nil {
+		t.Fatalf("error querying retention policies: %s", response.Error())
+	}
+
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			nameIdx := -1
+			for idx, col := range series.Columns {
+				if col == "name" {
+					nameIdx = idx
+				}
+			}
+			if nameIdx == -1 {
+				continue
+			}
+			for _, row := range series.Values {
+				if fmt.Sprintf("%v", row[nameIdx]) == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// listUsers returns the names of every user InfluxDB reports via SHOW USERS.
+func listUsers(t *testing.T, apiConfig influx.HTTPConfig) []string {
+	t.Helper()
+
+	cli, err := influx.NewHTTPClient(apiConfig)
+	if err != nil {
+		t.Fatalf("error creating InfluxDB client: %s", err)
+	}
+	defer cli.Close()
+
+	response, err := cli.Query(influx.NewQuery("SHOW USERS", "", ""))
+	if err != nil {
+		t.Fatalf("error querying users: %s", err)
+	}
+	if response.Error() != nil {
+		t.Fatalf("error querying users: %s", response.Error())
+	}
+
+	var users []string
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			userIdx := -1
+			for idx, col := range series.Columns {
+				if col == "user" {
+					userIdx = idx
+				}
+			}
+			if userIdx == -1 {
+				continue
+			}
+			for _, row := range series.Values {
+				users = append(users, fmt.Sprintf("%v", row[userIdx]))
+			}
+		}
+	}
+	return users
+}
+
+// TestInfluxdb_CreateUser_role verifies that a configured Role renders its
+// grants deterministically without any Commands being supplied, and that
+// ReconcileRoleGrants detects and corrects drift introduced out of band.
+func TestInfluxdb_CreateUser_role(t *testing.T) {
+	cleanup, config := prepareInfluxdbTestContainer(t)
+	defer cleanup()
+
+	db := new()
+	req := newdbplugin.InitializeRequest{
+		Config: makeConfig(config.connectionParams(), "roles", map[string]interface{}{
+			"reporting": map[string]interface{}{
+				"privileges": []map[string]interface{}{
+					{"database": "vault", "privilege": "read"},
+				},
+			},
+		}),
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, req)
+
+	password := "nuozxby98523u89bdfnkjl"
+	newUserReq := newdbplugin.NewUserRequest{
+		UsernameConfig: newdbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "reporting",
+		},
+		Password:   password,
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+	resp := dbtesting.AssertNewUser(t, db, newUserReq)
+
+	assertCredsExist(t, config.URL().String(), resp.Username, password)
+
+	diff, err := db.ReconcileRoleGrants(resp.Username, "reporting", true)
+	if err != nil {
+		t.Fatalf("error reconciling role grants: %s", err)
+	}
+	if !diff.InSync() {
+		t.Fatalf("expected grants to already be in sync, got %#v", diff)
+	}
+
+	// Introduce drift out of band and confirm a dry run reports it without
+	// correcting it.
+	if err := db.runQuery(fmt.Sprintf(`GRANT WRITE ON "vault" TO "%s"`, resp.Username)); err != nil {
+		t.Fatalf("error granting out of band: %s", err)
+	}
+
+	diff, err = db.ReconcileRoleGrants(resp.Username, "reporting", true)
+	if err != nil {
+		t.Fatalf("error reconciling role grants: %s", err)
+	}
+	if diff.InSync() {
+		t.Fatalf("expected drift to be detected")
+	}
+
+	diff, err = db.ReconcileRoleGrants(resp.Username, "reporting", false)
+	if err != nil {
+		t.Fatalf("error correcting role grants: %s", err)
+	}
+	if diff.InSync() {
+		t.Fatalf("expected the returned diff to reflect the drift that was corrected")
+	}
+
+	grants, err := db.currentGrants(resp.Username)
+	if err != nil {
+		t.Fatalf("error fetching grants: %s", err)
+	}
+	for _, g := range grants {
+		if g.Database == "vault" && !strings.EqualFold(g.Privilege, "READ") {
+			t.Fatalf("expected only the READ grant on vault to remain, got %#v", grants)
+		}
+	}
+}
+
 func TestUpdateUser_expiration(t *testing.T) {
 	// This test should end up with a no-op since the expiration doesn't do anything in Influx
 
@@ -406,6 +816,168 @@ func TestInfluxdb_RevokeUser(t *testing.T) {
 	}
 	assertCredsDoNotExist(t, config.URL().String(), newUserResp.Username, initialPassword)
 }
+
+// TestInfluxdb_CreateUser_passwordPolicy verifies that a password failing
+// the configured password policy is rejected before any CREATE USER
+// statement reaches InfluxDB, and that the user is consequently never
+// created.
+func TestInfluxdb_CreateUser_passwordPolicy(t *testing.T) {
+	cleanup, config := prepareInfluxdbTestContainer(t)
+	defer cleanup()
+
+	db := new()
+	req := newdbplugin.InitializeRequest{
+		Config: makeConfig(config.connectionParams(),
+			"password_min_length", 12,
+			"password_require_special", true,
+		),
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, req)
+
+	password := "short"
+	newUserReq := newdbplugin.NewUserRequest{
+		UsernameConfig: newdbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: newdbplugin.Statements{
+			Commands: []string{createUserStatements},
+		},
+		Password:   password,
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+
+	usersBefore := listUsers(t, config.apiConfig())
+
+	resp, err := db.NewUser(context.Background(), newUserReq)
+	if err == nil {
+		t.Fatalf("expected a password policy error, got nil")
+	}
+	if _, ok := err.(*policyViolationError); !ok {
+		t.Fatalf("expected a *policyViolationError, got %T: %s", err, err)
+	}
+	if resp.Username != "" {
+		t.Fatalf("expected no username to be returned, got %q", resp.Username)
+	}
+
+	assertCredsDoNotExist(t, config.URL().String(), "test", password)
+	if usersAfter := listUsers(t, config.apiConfig()); !reflect.DeepEqual(usersBefore, usersAfter) {
+		t.Fatalf("expected no user to be created, users before: %v, after: %v", usersBefore, usersAfter)
+	}
+}
+
+// generateSelfSignedCert returns a freshly generated, PEM-encoded
+// self-signed certificate and private key valid for commonName, for use
+// by the TLS-enabled test container.
+func generateSelfSignedCert(commonName string) (certPEM string, keyPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	return certPEM, keyPEM, nil
+}
+
+// writeTempFile writes contents to a temp file and returns its path, for
+// use with docker.RunOptions.CopyFromTo.
+func writeTempFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("could not write %s: %s", path, err)
+	}
+	return path
+}
+
+// TestInfluxdb_Initialize_tls exercises TLS connectivity both through the
+// discrete tls_* fields and through a url-form connection string, and
+// verifies that an untrusted CA is rejected unless insecure_tls is set.
+func TestInfluxdb_Initialize_tls(t *testing.T) {
+	cleanup, config := prepareInfluxdbTLSTestContainer(t)
+	defer cleanup()
+
+	t.Run("discrete fields with the correct CA succeed", func(t *testing.T) {
+		db := new()
+		defer db.Close()
+
+		req := newdbplugin.InitializeRequest{
+			Config:           config.connectionParams(),
+			VerifyConnection: true,
+		}
+		if _, err := db.Initialize(context.Background(), req); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	})
+
+	t.Run("url form with the correct CA succeeds", func(t *testing.T) {
+		db := new()
+		defer db.Close()
+
+		req := newdbplugin.InitializeRequest{
+			Config: map[string]interface{}{
+				"url":    config.url(),
+				"tls_ca": config.CACertPEM,
+			},
+			VerifyConnection: true,
+		}
+		if _, err := db.Initialize(context.Background(), req); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	})
+
+	t.Run("untrusted CA is rejected", func(t *testing.T) {
+		db := new()
+		defer db.Close()
+
+		params := config.Config.connectionParams()
+		params["tls"] = true
+
+		req := newdbplugin.InitializeRequest{
+			Config:           params,
+			VerifyConnection: true,
+		}
+		if _, err := db.Initialize(context.Background(), req); err == nil {
+			t.Fatalf("expected an error verifying an untrusted CA, got nil")
+		}
+	})
+
+	t.Run("untrusted CA is accepted when insecure_tls is set", func(t *testing.T) {
+		db := new()
+		defer db.Close()
+
+		params := config.Config.connectionParams()
+		params["tls"] = true
+		params["insecure_tls"] = true
+
+		req := newdbplugin.InitializeRequest{
+			Config:           params,
+			VerifyConnection: true,
+		}
+		if _, err := db.Initialize(context.Background(), req); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	})
+}
+
 func assertCredsExist(t testing.TB, address, username, password string) {
 	t.Helper()
 	err := testCredsExist(address, username, password)
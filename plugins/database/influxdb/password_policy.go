@@ -0,0 +1,152 @@
+package influxdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/mapstructure"
+)
+
+// passwordPolicy describes an optional password complexity policy applied
+// to every password Vault asks this plugin to set, mirroring the stricter
+// requirements InfluxDB itself has started to enforce on its own users.
+// It is configured once, as part of Initialize, and applied uniformly to
+// NewUser and UpdateUser regardless of any password_policy a role defines
+// on the Vault side.
+type passwordPolicy struct {
+	MinLength      int  `mapstructure:"password_min_length"`
+	RequireUpper   bool `mapstructure:"password_require_upper"`
+	RequireLower   bool `mapstructure:"password_require_lower"`
+	RequireDigit   bool `mapstructure:"password_require_digit"`
+	RequireSpecial bool `mapstructure:"password_require_special"`
+	DisallowCommon bool `mapstructure:"password_disallow_common"`
+}
+
+// commonPasswords is a small blacklist of trivial passwords rejected when
+// password_disallow_common is set. It is intentionally short: its purpose
+// is to catch obviously weak rotated credentials, not to be a
+// comprehensive dictionary.
+var commonPasswords = map[string]bool{
+	"password":  true,
+	"password1": true,
+	"123456":    true,
+	"12345678":  true,
+	"qwerty":    true,
+	"letmein":   true,
+	"admin":     true,
+	"influxdb":  true,
+	"changeme":  true,
+}
+
+// specialChars deliberately excludes ', ", ` and \: NewUser and UpdateUser
+// interpolate passwords, unescaped, into single-quoted InfluxQL string
+// literals (CREATE USER ... WITH PASSWORD '...', SET PASSWORD ... = '...'),
+// so a password containing any of those characters could break out of the
+// literal and inject additional statements. See unsafeChars, which rejects
+// them outright regardless of policy.
+const specialChars = "!@#$%^&*()-_=+[]{}|;:,.<>/?~"
+
+// unsafeChars are rejected unconditionally, even when no password policy is
+// configured, because they would let a password break out of the
+// single-quoted InfluxQL literals NewUser and UpdateUser build.
+const unsafeChars = "'\"`\\"
+
+func parsePasswordPolicy(raw map[string]interface{}) (passwordPolicy, error) {
+	var policy passwordPolicy
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &policy,
+	})
+	if err != nil {
+		return passwordPolicy{}, errwrap.Wrapf("error building password policy decoder: {{err}}", err)
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return passwordPolicy{}, errwrap.Wrapf("error decoding password policy configuration: {{err}}", err)
+	}
+	if policy.MinLength < 0 {
+		return passwordPolicy{}, fmt.Errorf("password_min_length must not be negative")
+	}
+	return policy, nil
+}
+
+func (p passwordPolicy) enabled() bool {
+	return p.MinLength > 0 || p.RequireUpper || p.RequireLower || p.RequireDigit ||
+		p.RequireSpecial || p.DisallowCommon
+}
+
+// policyViolationError distinguishes a rejected password from an error
+// returned by InfluxDB itself, so callers and operators can tell the two
+// apart.
+type policyViolationError struct {
+	reasons []string
+}
+
+func (e *policyViolationError) Error() string {
+	return fmt.Sprintf("password does not satisfy the configured password policy: %s", strings.Join(e.reasons, "; "))
+}
+
+// validate checks the candidate password against every rule the policy has
+// enabled, returning a *policyViolationError listing every rule that
+// failed. unsafeChars are rejected even if no rule is configured, since
+// they threaten the query-building code itself rather than just password
+// strength.
+func (p passwordPolicy) validate(password string) error {
+	var reasons []string
+
+	if strings.ContainsAny(password, unsafeChars) {
+		reasons = append(reasons, fmt.Sprintf("must not contain any of the following characters: %s", unsafeChars))
+	}
+
+	if !p.enabled() {
+		if len(reasons) > 0 {
+			return &policyViolationError{reasons: reasons}
+		}
+		return nil
+	}
+
+	if p.MinLength > 0 && len(password) < p.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.RequireUpper && !containsRune(password, isUpper) {
+		reasons = append(reasons, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !containsRune(password, isLower) {
+		reasons = append(reasons, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !containsRune(password, isDigit) {
+		reasons = append(reasons, "must contain a digit")
+	}
+	if p.RequireSpecial && !strings.ContainsAny(password, specialChars) {
+		reasons = append(reasons, "must contain a special character")
+	}
+	if p.DisallowCommon && commonPasswords[strings.ToLower(password)] {
+		reasons = append(reasons, "must not be a commonly used password")
+	}
+
+	if len(reasons) > 0 {
+		return &policyViolationError{reasons: reasons}
+	}
+	return nil
+}
+
+func containsRune(s string, match func(rune) bool) bool {
+	for _, r := range s {
+		if match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUpper(r rune) bool {
+	return r >= 'A' && r <= 'Z'
+}
+
+func isLower(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
@@ -0,0 +1,114 @@
+package influxdb
+
+import "testing"
+
+func TestPasswordPolicy_validate(t *testing.T) {
+	tests := map[string]struct {
+		policy   passwordPolicy
+		password string
+		wantErr  bool
+	}{
+		"disabled policy accepts anything safe": {
+			policy:   passwordPolicy{},
+			password: "a",
+			wantErr:  false,
+		},
+		"unsafe character rejected even with no policy configured": {
+			policy:   passwordPolicy{},
+			password: "abc'def",
+			wantErr:  true,
+		},
+		"unsafe characters rejected regardless of an otherwise-satisfied policy": {
+			policy:   passwordPolicy{RequireSpecial: true},
+			password: `Abcdefg1!"`,
+			wantErr:  true,
+		},
+		"min length satisfied": {
+			policy:   passwordPolicy{MinLength: 8},
+			password: "longenough",
+			wantErr:  false,
+		},
+		"min length violated": {
+			policy:   passwordPolicy{MinLength: 8},
+			password: "short",
+			wantErr:  true,
+		},
+		"require upper satisfied": {
+			policy:   passwordPolicy{RequireUpper: true},
+			password: "Abcdefg1",
+			wantErr:  false,
+		},
+		"require upper violated": {
+			policy:   passwordPolicy{RequireUpper: true},
+			password: "abcdefg1",
+			wantErr:  true,
+		},
+		"require lower satisfied": {
+			policy:   passwordPolicy{RequireLower: true},
+			password: "ABCDEFg1",
+			wantErr:  false,
+		},
+		"require lower violated": {
+			policy:   passwordPolicy{RequireLower: true},
+			password: "ABCDEFG1",
+			wantErr:  true,
+		},
+		"require digit satisfied": {
+			policy:   passwordPolicy{RequireDigit: true},
+			password: "Abcdefg1",
+			wantErr:  false,
+		},
+		"require digit violated": {
+			policy:   passwordPolicy{RequireDigit: true},
+			password: "Abcdefgh",
+			wantErr:  true,
+		},
+		"require special satisfied": {
+			policy:   passwordPolicy{RequireSpecial: true},
+			password: "Abcdefg!",
+			wantErr:  false,
+		},
+		"require special violated": {
+			policy:   passwordPolicy{RequireSpecial: true},
+			password: "Abcdefg1",
+			wantErr:  true,
+		},
+		"disallow common satisfied": {
+			policy:   passwordPolicy{DisallowCommon: true},
+			password: "notInTheBlacklist",
+			wantErr:  false,
+		},
+		"disallow common violated is case insensitive": {
+			policy:   passwordPolicy{DisallowCommon: true},
+			password: "PaSSword",
+			wantErr:  true,
+		},
+		"multiple rules combine": {
+			policy: passwordPolicy{
+				MinLength:      10,
+				RequireUpper:   true,
+				RequireDigit:   true,
+				RequireSpecial: true,
+			},
+			password: "Abcdefghij1!",
+			wantErr:  false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.policy.validate(test.password)
+			if test.wantErr && err == nil {
+				t.Fatalf("expected policy violation, got nil")
+			}
+			if !test.wantErr && err != nil {
+				t.Fatalf("expected no error, got: %s", err)
+			}
+			if test.wantErr {
+				if _, ok := err.(*policyViolationError); !ok {
+					t.Fatalf("expected a *policyViolationError, got %T", err)
+				}
+			}
+		})
+	}
+}
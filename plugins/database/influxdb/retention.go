@@ -0,0 +1,205 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// retentionPolicy describes a retention policy to create alongside a
+// dynamic user, letting Vault provision ephemeral InfluxDB tenants with
+// lifecycle-bound storage policies rather than just credentials.
+type retentionPolicy struct {
+	Name          string `json:"name"`
+	Database      string `json:"database"`
+	Duration      string `json:"duration"`
+	Replication   int    `json:"replication"`
+	ShardDuration string `json:"shard_duration"`
+	Default       bool   `json:"default"`
+}
+
+// retentionPoliciesStatement is the JSON shape a role embeds in one of the
+// Statements.Commands entries to request retention policies be created
+// alongside the user.
+type retentionPoliciesStatement struct {
+	RetentionPolicies []retentionPolicy `json:"retention_policies"`
+}
+
+// parseRetentionPoliciesStatement attempts to interpret a Commands entry
+// as a retentionPoliciesStatement, the same way parseGrantsStatement does
+// for grants.
+func parseRetentionPoliciesStatement(command string) (retentionPoliciesStatement, bool) {
+	var stmt retentionPoliciesStatement
+	if err := json.Unmarshal([]byte(strings.TrimSpace(command)), &stmt); err != nil {
+		return retentionPoliciesStatement{}, false
+	}
+	if len(stmt.RetentionPolicies) == 0 {
+		return retentionPoliciesStatement{}, false
+	}
+	return stmt, true
+}
+
+func (p retentionPolicy) createStatement() (string, error) {
+	if p.Name == "" {
+		return "", fmt.Errorf("retention policy is missing a name")
+	}
+	if !validIdentifier.MatchString(p.Name) {
+		return "", fmt.Errorf("invalid retention policy name %q", p.Name)
+	}
+	if p.Database == "" {
+		return "", fmt.Errorf("retention policy %q is missing a database", p.Name)
+	}
+	if !validIdentifier.MatchString(p.Database) {
+		return "", fmt.Errorf("invalid database name %q", p.Database)
+	}
+	if p.Duration == "" {
+		return "", fmt.Errorf("retention policy %q is missing a duration", p.Name)
+	}
+	if !validDurationLiteral.MatchString(p.Duration) {
+		return "", fmt.Errorf("invalid duration %q", p.Duration)
+	}
+	if p.ShardDuration != "" && !validDurationLiteral.MatchString(p.ShardDuration) {
+		return "", fmt.Errorf("invalid shard duration %q", p.ShardDuration)
+	}
+
+	replication := p.Replication
+	if replication == 0 {
+		replication = 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `CREATE RETENTION POLICY "%s" ON "%s" DURATION %s REPLICATION %d`,
+		p.Name, p.Database, p.Duration, replication)
+	if p.ShardDuration != "" {
+		fmt.Fprintf(&b, " SHARD DURATION %s", p.ShardDuration)
+	}
+	if p.Default {
+		b.WriteString(" DEFAULT")
+	}
+	return b.String(), nil
+}
+
+func (p retentionPolicy) dropStatement() string {
+	return fmt.Sprintf(`DROP RETENTION POLICY "%s" ON "%s"`, p.Name, p.Database)
+}
+
+// policyNamePrefix returns the prefix applyRetentionPolicies encodes into
+// the InfluxDB-visible name of every policy it creates for username. This
+// makes policy ownership discoverable directly from InfluxDB's own state
+// via findOwnedRetentionPolicies, rather than from process-local
+// bookkeeping that wouldn't survive a Vault restart or plugin reload.
+func policyNamePrefix(username string) string {
+	return fmt.Sprintf("vault-%s-", username)
+}
+
+// applyRetentionPolicies creates every policy in stmt on behalf of
+// username, renaming each one to carry username as a prefix so it can
+// later be attributed back to its owner, and returns the policies it
+// successfully created (under their actual, prefixed names) along with the
+// first error encountered, if any.
+func (i *Influxdb) applyRetentionPolicies(username string, stmt retentionPoliciesStatement) ([]retentionPolicy, error) {
+	var created []retentionPolicy
+	for _, p := range stmt.RetentionPolicies {
+		owned := p
+		owned.Name = policyNamePrefix(username) + p.Name
+		q, err := owned.createStatement()
+		if err != nil {
+			return created, err
+		}
+		if err := i.runQuery(q); err != nil {
+			return created, errwrap.Wrapf(fmt.Sprintf("error creating retention policy %q: {{err}}", p.Name), err)
+		}
+		created = append(created, owned)
+	}
+	return created, nil
+}
+
+// dropRetentionPolicies drops every policy in policies, attempting all of
+// them even if one fails, and returns the first error encountered.
+func (i *Influxdb) dropRetentionPolicies(policies []retentionPolicy) error {
+	var firstErr error
+	for _, p := range policies {
+		if err := i.runQuery(p.dropStatement()); err != nil && firstErr == nil {
+			firstErr = errwrap.Wrapf(fmt.Sprintf("error dropping retention policy %q: {{err}}", p.Name), err)
+		}
+	}
+	return firstErr
+}
+
+// databases returns the names of every database known to the InfluxDB
+// server, so findOwnedRetentionPolicies can scan all of them without
+// needing to be told in advance which databases a user's policies live on.
+func (i *Influxdb) databases() ([]string, error) {
+	response, err := i.client.Query(influx.NewQuery("SHOW DATABASES", "", ""))
+	if err != nil {
+		return nil, err
+	}
+	if response != nil && response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	var databases []string
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			nameIdx := -1
+			for idx, col := range series.Columns {
+				if col == "name" {
+					nameIdx = idx
+				}
+			}
+			if nameIdx == -1 {
+				continue
+			}
+			for _, row := range series.Values {
+				databases = append(databases, fmt.Sprintf("%v", row[nameIdx]))
+			}
+		}
+	}
+	return databases, nil
+}
+
+// findOwnedRetentionPolicies discovers the retention policies previously
+// created for username by listing InfluxDB's own retention policies across
+// databases and matching the name prefix applyRetentionPolicies encoded
+// into them. Because it derives ownership entirely from InfluxDB's state
+// rather than any in-process record, it finds a user's policies even if
+// the plugin instance that created them has since restarted or been
+// reloaded.
+func (i *Influxdb) findOwnedRetentionPolicies(username string, databases []string) ([]retentionPolicy, error) {
+	prefix := policyNamePrefix(username)
+
+	var owned []retentionPolicy
+	for _, db := range databases {
+		response, err := i.client.Query(influx.NewQuery(fmt.Sprintf(`SHOW RETENTION POLICIES ON "%s"`, db), "", ""))
+		if err != nil {
+			return nil, err
+		}
+		if response != nil && response.Error() != nil {
+			return nil, response.Error()
+		}
+
+		for _, result := range response.Results {
+			for _, series := range result.Series {
+				nameIdx := -1
+				for idx, col := range series.Columns {
+					if col == "name" {
+						nameIdx = idx
+					}
+				}
+				if nameIdx == -1 {
+					continue
+				}
+				for _, row := range series.Values {
+					name := fmt.Sprintf("%v", row[nameIdx])
+					if strings.HasPrefix(name, prefix) {
+						owned = append(owned, retentionPolicy{Name: name, Database: db})
+					}
+				}
+			}
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,107 @@
+package influxdb
+
+import "testing"
+
+func TestRetentionPolicy_createStatement(t *testing.T) {
+	tests := map[string]struct {
+		policy  retentionPolicy
+		want    string
+		wantErr bool
+	}{
+		"minimal policy defaults replication to 1": {
+			policy: retentionPolicy{Name: "short", Database: "metrics", Duration: "24h"},
+			want:   `CREATE RETENTION POLICY "short" ON "metrics" DURATION 24h REPLICATION 1`,
+		},
+		"full policy": {
+			policy: retentionPolicy{
+				Name:          "short",
+				Database:      "metrics",
+				Duration:      "24h",
+				Replication:   3,
+				ShardDuration: "1h",
+				Default:       true,
+			},
+			want: `CREATE RETENTION POLICY "short" ON "metrics" DURATION 24h REPLICATION 3 SHARD DURATION 1h DEFAULT`,
+		},
+		"missing name": {
+			policy:  retentionPolicy{Database: "metrics", Duration: "24h"},
+			wantErr: true,
+		},
+		"missing database": {
+			policy:  retentionPolicy{Name: "short", Duration: "24h"},
+			wantErr: true,
+		},
+		"missing duration": {
+			policy:  retentionPolicy{Name: "short", Database: "metrics"},
+			wantErr: true,
+		},
+		"database with a quote is rejected": {
+			policy:  retentionPolicy{Name: "short", Database: `metrics"; DROP DATABASE "vault`, Duration: "24h"},
+			wantErr: true,
+		},
+		"name with a quote is rejected": {
+			policy:  retentionPolicy{Name: `short" ON "metrics`, Database: "metrics", Duration: "24h"},
+			wantErr: true,
+		},
+		"malformed duration is rejected": {
+			policy:  retentionPolicy{Name: "short", Database: "metrics", Duration: `24h; DROP DATABASE "vault`},
+			wantErr: true,
+		},
+		"malformed shard duration is rejected": {
+			policy: retentionPolicy{
+				Name:          "short",
+				Database:      "metrics",
+				Duration:      "24h",
+				ShardDuration: `1h; DROP DATABASE "vault`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := test.policy.createStatement()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRetentionPoliciesStatement(t *testing.T) {
+	tests := map[string]struct {
+		command string
+		wantOK  bool
+	}{
+		"plain influxql is not a retention policies statement": {
+			command: createUserStatements,
+			wantOK:  false,
+		},
+		"retention policies list": {
+			command: `{"retention_policies":[{"name":"short","database":"metrics","duration":"24h"}]}`,
+			wantOK:  true,
+		},
+		"empty json object": {
+			command: `{}`,
+			wantOK:  false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, ok := parseRetentionPoliciesStatement(test.command)
+			if ok != test.wantOK {
+				t.Fatalf("got ok=%v, want %v", ok, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package influxdb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Privilege is a single entry in a Role: either a database-scoped
+// READ/WRITE/ALL grant, or a cluster-wide admin grant. An entry cannot be
+// both; Admin implies Database and Privilege are left empty.
+type Privilege struct {
+	Database  string `mapstructure:"database"`
+	Privilege string `mapstructure:"privilege"`
+	Admin     bool   `mapstructure:"admin"`
+}
+
+// Role is a named, validated bundle of Privileges. Roles are configured
+// once, under the `roles` key of the plugin's Initialize config, and
+// looked up by NewUserRequest.UsernameConfig.RoleName. When a role is
+// found for the requested RoleName, its privileges are rendered into
+// GRANT statements directly, bypassing the Commands template entirely.
+type Role struct {
+	Privileges []Privilege `mapstructure:"privileges"`
+}
+
+// validIdentifier matches InfluxDB identifiers (database and retention
+// policy names) that are safe to interpolate into a double-quoted InfluxQL
+// identifier without risk of a query-injection breakout. It's shared by
+// every structured-input path that renders InfluxQL from JSON: roles,
+// grants and retention policies.
+var validIdentifier = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// validDurationLiteral matches InfluxQL duration literals (e.g. "24h",
+// "1h30m", "INF"), rejecting anything that isn't a well-formed duration so
+// one can't be used to break out of a retention policy statement.
+var validDurationLiteral = regexp.MustCompile(`^(INF|0|(\d+(ns|µs|ms|µ|u|s|m|h|d|w))+)$`)
+
+// parseRoles decodes and validates the `roles` block of the Initialize
+// config, failing fast on bad database names, duplicate entries, and
+// entries that combine admin with a database-scoped privilege. A missing
+// `roles` key is not an error: roles are optional, and Commands-driven
+// statements remain the fallback.
+func parseRoles(raw map[string]interface{}) (map[string]Role, error) {
+	rolesRaw, ok := raw["roles"]
+	if !ok || rolesRaw == nil {
+		return nil, nil
+	}
+
+	var roles map[string]Role
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           &roles,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("error building roles decoder: {{err}}", err)
+	}
+	if err := decoder.Decode(rolesRaw); err != nil {
+		return nil, errwrap.Wrapf("error decoding roles configuration: {{err}}", err)
+	}
+
+	for name, role := range roles {
+		if err := role.validate(); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("invalid role %q: {{err}}", name), err)
+		}
+	}
+
+	return roles, nil
+}
+
+// validate fails fast on the mistakes an operator is most likely to make
+// when hand-writing a role: an unparseable database name, an
+// unrecognized privilege, a privilege entry that tries to be both an
+// admin grant and a scoped grant, and duplicate entries for the same
+// database.
+func (r Role) validate() error {
+	seen := make(map[string]bool, len(r.Privileges))
+	for _, p := range r.Privileges {
+		if p.Admin {
+			if p.Database != "" || p.Privilege != "" {
+				return fmt.Errorf("a privilege entry cannot combine admin with a database-scoped privilege")
+			}
+			continue
+		}
+
+		if p.Database == "" {
+			return fmt.Errorf("privilege is missing a database")
+		}
+		if !validIdentifier.MatchString(p.Database) {
+			return fmt.Errorf("invalid database name %q", p.Database)
+		}
+
+		priv := strings.ToUpper(p.Privilege)
+		switch priv {
+		case "READ", "WRITE", "ALL":
+		default:
+			return fmt.Errorf("unsupported privilege %q on database %q, must be one of READ, WRITE, ALL", p.Privilege, p.Database)
+		}
+
+		if seen[p.Database] {
+			return fmt.Errorf("duplicate privilege entry for database %q", p.Database)
+		}
+		seen[p.Database] = true
+	}
+	return nil
+}
+
+// grantsStatement renders r as the same grantsStatement applyGrants
+// already knows how to execute, so role-driven and Commands-driven grants
+// share one code path.
+func (r Role) grantsStatement() grantsStatement {
+	var stmt grantsStatement
+	for _, p := range r.Privileges {
+		if p.Admin {
+			stmt.Admin = true
+			continue
+		}
+		stmt.Grants = append(stmt.Grants, grant{Database: p.Database, Privilege: p.Privilege})
+	}
+	return stmt
+}
+
+// lookupRole returns the configured role for roleName, if any.
+func (i *Influxdb) lookupRole(roleName string) (Role, bool) {
+	if i.roles == nil {
+		return Role{}, false
+	}
+	role, ok := i.roles[roleName]
+	return role, ok
+}
+
+// GrantDiff describes how a user's actual InfluxDB grants differ from
+// what its role expects.
+type GrantDiff struct {
+	// Missing are privileges the role expects but the user doesn't
+	// currently hold.
+	Missing []grant
+	// Extra are privileges the user currently holds that aren't part of
+	// the role.
+	Extra []activeGrant
+	// AdminMissing is true when the role declares Privilege{Admin: true}
+	// but the user doesn't currently hold InfluxDB's cluster-wide admin
+	// privilege.
+	AdminMissing bool
+	// AdminExtra is true when the user currently holds InfluxDB's
+	// cluster-wide admin privilege but the role doesn't expect it.
+	AdminExtra bool
+}
+
+// InSync reports whether the user's grants, including cluster-wide admin
+// status, already match its role.
+func (d *GrantDiff) InSync() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && !d.AdminMissing && !d.AdminExtra
+}
+
+// diffRoleGrants compares role against a user's current database-scoped
+// grants and admin status (SHOW GRANTS FOR never reports admin status, so
+// currentAdmin must come from a separate SHOW USERS lookup; see isAdmin).
+func diffRoleGrants(role Role, current []activeGrant, currentAdmin bool) *GrantDiff {
+	currentByDB := make(map[string]string, len(current))
+	for _, g := range current {
+		priv := strings.ToUpper(g.Privilege)
+		// SHOW GRANTS FOR reports an ALL grant as "ALL PRIVILEGES".
+		if priv == "ALL PRIVILEGES" {
+			priv = "ALL"
+		}
+		currentByDB[g.Database] = priv
+	}
+
+	expectedAdmin := false
+	expectedByDB := make(map[string]string, len(role.Privileges))
+	for _, p := range role.Privileges {
+		if p.Admin {
+			expectedAdmin = true
+			continue
+		}
+		expectedByDB[p.Database] = strings.ToUpper(p.Privilege)
+	}
+
+	diff := &GrantDiff{
+		AdminMissing: expectedAdmin && !currentAdmin,
+		AdminExtra:   !expectedAdmin && currentAdmin,
+	}
+	for db, priv := range expectedByDB {
+		if currentByDB[db] != priv {
+			diff.Missing = append(diff.Missing, grant{Database: db, Privilege: priv})
+		}
+	}
+	for db, priv := range currentByDB {
+		if priv == "" || priv == "NO PRIVILEGES" {
+			continue
+		}
+		if expectedByDB[db] != priv {
+			diff.Extra = append(diff.Extra, activeGrant{Database: db, Privilege: priv})
+		}
+	}
+	return diff
+}
+
+// ReconcileRoleGrants compares username's actual InfluxDB grants against
+// what roleName expects and, unless dryRun is set, corrects any drift by
+// granting what's missing and revoking what shouldn't be there. It always
+// returns the diff it found, so a dry run can be inspected before being
+// applied for real. This mirrors the idempotent, re-apply-everything
+// shape of RotateRootCredentials, but for a role's grants instead of the
+// plugin's own connection credentials.
+func (i *Influxdb) ReconcileRoleGrants(username, roleName string, dryRun bool) (*GrantDiff, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.Initialized {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+
+	role, ok := i.lookupRole(roleName)
+	if !ok {
+		return nil, fmt.Errorf("no role named %q is configured", roleName)
+	}
+
+	current, err := i.currentGrants(username)
+	if err != nil {
+		return nil, errwrap.Wrapf("error enumerating current grants: {{err}}", err)
+	}
+
+	currentAdmin, err := i.isAdmin(username)
+	if err != nil {
+		return nil, errwrap.Wrapf("error checking admin status: {{err}}", err)
+	}
+
+	diff := diffRoleGrants(role, current, currentAdmin)
+	if dryRun || diff.InSync() {
+		return diff, nil
+	}
+
+	// Extra grants are revoked before missing ones are granted: a database
+	// can appear in both, and revoking ALL after the corrected grant would
+	// clear that grant again.
+	if len(diff.Extra) > 0 {
+		if err := i.revokeAll(username, diff.Extra); err != nil {
+			return diff, err
+		}
+	}
+	if len(diff.Missing) > 0 {
+		if err := i.applyGrants(username, grantsStatement{Grants: diff.Missing}); err != nil {
+			return diff, err
+		}
+	}
+	if diff.AdminMissing {
+		if err := i.runQuery(fmt.Sprintf(`GRANT ALL PRIVILEGES TO "%s"`, username)); err != nil {
+			return diff, errwrap.Wrapf("error granting admin privileges: {{err}}", err)
+		}
+	}
+	if diff.AdminExtra {
+		if err := i.runQuery(fmt.Sprintf(`REVOKE ALL PRIVILEGES FROM "%s"`, username)); err != nil {
+			return diff, errwrap.Wrapf("error revoking admin privileges: {{err}}", err)
+		}
+	}
+
+	return diff, nil
+}
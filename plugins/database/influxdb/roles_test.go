@@ -0,0 +1,154 @@
+package influxdb
+
+import "testing"
+
+func TestRole_validate(t *testing.T) {
+	tests := map[string]struct {
+		role    Role
+		wantErr bool
+	}{
+		"empty role": {
+			role: Role{},
+		},
+		"valid scoped privileges": {
+			role: Role{Privileges: []Privilege{
+				{Database: "metrics", Privilege: "read"},
+				{Database: "events", Privilege: "WRITE"},
+			}},
+		},
+		"valid admin entry": {
+			role: Role{Privileges: []Privilege{{Admin: true}}},
+		},
+		"admin combined with database is rejected": {
+			role:    Role{Privileges: []Privilege{{Admin: true, Database: "metrics"}}},
+			wantErr: true,
+		},
+		"admin combined with privilege is rejected": {
+			role:    Role{Privileges: []Privilege{{Admin: true, Privilege: "READ"}}},
+			wantErr: true,
+		},
+		"missing database": {
+			role:    Role{Privileges: []Privilege{{Privilege: "READ"}}},
+			wantErr: true,
+		},
+		"invalid database name": {
+			role:    Role{Privileges: []Privilege{{Database: "bad db!", Privilege: "READ"}}},
+			wantErr: true,
+		},
+		"unsupported privilege": {
+			role:    Role{Privileges: []Privilege{{Database: "metrics", Privilege: "DELETE"}}},
+			wantErr: true,
+		},
+		"duplicate database entries": {
+			role: Role{Privileges: []Privilege{
+				{Database: "metrics", Privilege: "READ"},
+				{Database: "metrics", Privilege: "WRITE"},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.role.validate()
+			if test.wantErr && err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+			if !test.wantErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestDiffRoleGrants(t *testing.T) {
+	role := Role{Privileges: []Privilege{
+		{Database: "metrics", Privilege: "READ"},
+		{Database: "events", Privilege: "ALL"},
+	}}
+
+	t.Run("in sync", func(t *testing.T) {
+		current := []activeGrant{
+			{Database: "metrics", Privilege: "READ"},
+			{Database: "events", Privilege: "ALL"},
+		}
+		diff := diffRoleGrants(role, current, false)
+		if !diff.InSync() {
+			t.Fatalf("expected diff to be in sync, got %#v", diff)
+		}
+	})
+
+	t.Run("missing a grant", func(t *testing.T) {
+		current := []activeGrant{
+			{Database: "metrics", Privilege: "READ"},
+		}
+		diff := diffRoleGrants(role, current, false)
+		if len(diff.Missing) != 1 || diff.Missing[0].Database != "events" {
+			t.Fatalf("expected a missing grant on events, got %#v", diff.Missing)
+		}
+		if len(diff.Extra) != 0 {
+			t.Fatalf("expected no extra grants, got %#v", diff.Extra)
+		}
+	})
+
+	t.Run("extra grant", func(t *testing.T) {
+		current := []activeGrant{
+			{Database: "metrics", Privilege: "READ"},
+			{Database: "events", Privilege: "ALL"},
+			{Database: "scratch", Privilege: "WRITE"},
+		}
+		diff := diffRoleGrants(role, current, false)
+		if len(diff.Extra) != 1 || diff.Extra[0].Database != "scratch" {
+			t.Fatalf("expected an extra grant on scratch, got %#v", diff.Extra)
+		}
+	})
+
+	t.Run("mismatched privilege counts as both missing and extra", func(t *testing.T) {
+		current := []activeGrant{
+			{Database: "metrics", Privilege: "WRITE"},
+			{Database: "events", Privilege: "ALL"},
+		}
+		diff := diffRoleGrants(role, current, false)
+		if len(diff.Missing) != 1 || diff.Missing[0].Privilege != "READ" {
+			t.Fatalf("expected a missing READ grant on metrics, got %#v", diff.Missing)
+		}
+		if len(diff.Extra) != 1 || diff.Extra[0].Privilege != "WRITE" {
+			t.Fatalf("expected an extra WRITE grant on metrics, got %#v", diff.Extra)
+		}
+	})
+
+	adminRole := Role{Privileges: []Privilege{{Admin: true}}}
+
+	t.Run("missing admin privilege is detected", func(t *testing.T) {
+		diff := diffRoleGrants(adminRole, nil, false)
+		if !diff.AdminMissing {
+			t.Fatalf("expected AdminMissing, got %#v", diff)
+		}
+		if diff.AdminExtra {
+			t.Fatalf("expected AdminExtra to be false, got %#v", diff)
+		}
+		if diff.InSync() {
+			t.Fatalf("expected diff not to be in sync")
+		}
+	})
+
+	t.Run("extra admin privilege is detected", func(t *testing.T) {
+		diff := diffRoleGrants(role, nil, true)
+		if !diff.AdminExtra {
+			t.Fatalf("expected AdminExtra, got %#v", diff)
+		}
+		if diff.AdminMissing {
+			t.Fatalf("expected AdminMissing to be false, got %#v", diff)
+		}
+		if diff.InSync() {
+			t.Fatalf("expected diff not to be in sync")
+		}
+	})
+
+	t.Run("admin role with admin privilege held is in sync", func(t *testing.T) {
+		diff := diffRoleGrants(adminRole, nil, true)
+		if !diff.InSync() {
+			t.Fatalf("expected diff to be in sync, got %#v", diff)
+		}
+	})
+}